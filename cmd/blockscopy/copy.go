@@ -0,0 +1,672 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/storage/tsdb/bucketindex"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/grafana/dskit/backoff"
+	"github.com/grafana/dskit/concurrency"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	delim = "/" // Used by Cortex to delimit tenants and blocks, and objects within blocks.
+
+	// defaultBlockCopyBufferSizeBytes is the streaming copy buffer size used wherever no
+	// configured size is available, e.g. copyObject's marker-file copies, which are far too
+	// small for the buffer size to matter.
+	defaultBlockCopyBufferSizeBytes = 16 * 1024 * 1024
+
+	// crc32cPolynomial is the polynomial used for the post-copy integrity check (GCS' native
+	// object checksum), so it matches what operators are used to seeing in GCS tooling even
+	// though we compute it ourselves to stay backend-agnostic.
+	crc32cPolynomial = crc32.Castagnoli
+)
+
+// serverSideCopier is implemented by destination bucket backends that can copy an object
+// from another bucket of the same provider (e.g. GCS-to-GCS) without streaming its content
+// through this process. Backends that don't implement it, or pairs of different providers,
+// fall back to copyObject's streaming Get/Upload.
+type serverSideCopier interface {
+	ServerSideCopy(ctx context.Context, src objstore.BucketReader, srcName, dstName string) error
+}
+
+func copyBlocks(ctx context.Context, cfg config, logger log.Logger, reg prometheus.Registerer, m *metrics) error {
+	enabledUsers := map[string]struct{}{}
+	disabledUsers := map[string]struct{}{}
+
+	for _, u := range cfg.enabledUsers {
+		enabledUsers[u] = struct{}{}
+	}
+	for _, u := range cfg.disabledUsers {
+		disabledUsers[u] = struct{}{}
+	}
+
+	sourceBucket, err := openBucket(ctx, logger, reg, cfg.sourceBucketConfigFile, "source", cfg.gcsChunkedRewriteThresholdBytes)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open source bucket")
+	}
+	defer sourceBucket.Close()
+
+	destBucket, err := openBucket(ctx, logger, reg, cfg.destBucketConfigFile, "destination", cfg.gcsChunkedRewriteThresholdBytes)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open destination bucket")
+	}
+	defer destBucket.Close()
+
+	tenants, err := listTenants(ctx, sourceBucket)
+	if err != nil {
+		return errors.Wrapf(err, "failed to list tenants")
+	}
+
+	filter, err := buildBlockFilter(cfg)
+	if err != nil {
+		return errors.Wrap(err, "invalid block filter configuration")
+	}
+
+	return concurrency.ForEachUser(ctx, tenants, cfg.tenantConcurrency, func(ctx context.Context, tenantID string) error {
+		if !isAllowedUser(enabledUsers, disabledUsers, tenantID) {
+			return nil
+		}
+
+		logger := log.With(logger, "tenantID", tenantID)
+
+		blocks, err := resolveTenantBlocks(ctx, logger, sourceBucket, tenantID, cfg.bucketIndexMaxStaleness, m)
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to list blocks for tenant", "err", err)
+			return errors.Wrapf(err, "failed to list blocks for tenant %v", tenantID)
+		}
+
+		markers, err := listBlockMarkersForTenant(ctx, sourceBucket, tenantID, destBucket.Name())
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to list blocks markers for tenant", "err", err)
+			return errors.Wrapf(err, "failed to list block markers for tenant %v", tenantID)
+		}
+
+		var blockIDs []string
+		for _, b := range blocks {
+			blockIDs = append(blockIDs, b.String())
+		}
+
+		// copiedMetas collects the meta.json of every block copied this cycle, so
+		// updateDestinationBucketIndex can fold them into the destination bucket index without
+		// re-listing and re-fetching every block already there. Appended from multiple
+		// goroutines below, guarded by copiedMetasMu.
+		var copiedMetasMu sync.Mutex
+		var copiedMetas []*metadata.Meta
+
+		// We use ForEachUser here to keep processing other blocks, if the block fails. We pass block IDs as "users".
+		err = concurrency.ForEachUser(ctx, blockIDs, cfg.blocksConcurrency, func(ctx context.Context, blockIDStr string) error {
+			blockID, err := ulid.Parse(blockIDStr)
+			if err != nil {
+				return err
+			}
+
+			logger := log.With(logger, "block", blockID)
+
+			if markers[blockID].copied {
+				level.Debug(logger).Log("msg", "skipping block because it has been copied already")
+				return nil
+			}
+
+			if markers[blockID].deletion {
+				level.Debug(logger).Log("msg", "skipping block because it is marked for deletion")
+				return nil
+			}
+
+			if !filter.shardAllows(blockID) {
+				level.Debug(logger).Log("msg", "skipping block, not part of this shard")
+				return nil
+			}
+
+			if filter.needsMeta() {
+				meta, err := loadMetaJSONFile(ctx, sourceBucket, tenantID, blockID)
+				if err != nil {
+					level.Error(logger).Log("msg", "skipping block, failed to read meta.json file", "err", err)
+					return err
+				}
+
+				if ok, reason := filter.allows(meta); !ok {
+					level.Debug(logger).Log("msg", "skipping block, filtered out", "reason", reason)
+					return nil
+				}
+			}
+
+			if cfg.dryRun {
+				level.Info(logger).Log("msg", "would copy block, but skipping due to dry-run")
+				return nil
+			}
+
+			level.Info(logger).Log("msg", "copying block")
+
+			err = copySingleBlock(ctx, tenantID, blockID, sourceBucket, destBucket, cfg, m)
+			if err != nil {
+				m.blocksCopyFailed.Inc()
+				level.Error(logger).Log("msg", "failed to copy block", "err", err)
+				return err
+			}
+
+			m.blocksCopied.Inc()
+			level.Info(logger).Log("msg", "block copied successfully")
+
+			err = uploadCopiedMarkerFile(ctx, sourceBucket, tenantID, blockID, destBucket.Name())
+			if err != nil {
+				level.Error(logger).Log("msg", "failed to upload copied-marker file for block", "block", blockID.String(), "err", err)
+				return err
+			}
+
+			destMeta, err := loadMetaJSONFile(ctx, destBucket, tenantID, blockID)
+			if err != nil {
+				level.Error(logger).Log("msg", "failed to read back meta.json for destination bucket index update", "err", err)
+				return err
+			}
+			copiedMetasMu.Lock()
+			copiedMetas = append(copiedMetas, &destMeta)
+			copiedMetasMu.Unlock()
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		var syncedDeletionMarks []*bucketindex.BlockDeletionMark
+		if cfg.syncDeletionMarkers && !cfg.dryRun {
+			syncedDeletionMarks, err = syncDeletionMarkersForTenant(ctx, logger, sourceBucket, destBucket, tenantID, blocks, cfg.deletionMarkMinAge, m)
+			if err != nil {
+				level.Error(logger).Log("msg", "failed to sync deletion markers for tenant", "err", err)
+				return errors.Wrapf(err, "failed to sync deletion markers for tenant %v", tenantID)
+			}
+		}
+
+		if !cfg.dryRun {
+			if err := updateDestinationBucketIndex(ctx, logger, destBucket, tenantID, copiedMetas, syncedDeletionMarks); err != nil {
+				level.Error(logger).Log("msg", "failed to update destination bucket index for tenant", "err", err)
+				return errors.Wrapf(err, "failed to update destination bucket index for tenant %v", tenantID)
+			}
+		}
+
+		return nil
+	})
+}
+
+func isAllowedUser(enabled map[string]struct{}, disabled map[string]struct{}, tenantID string) bool {
+	if len(enabled) > 0 {
+		if _, ok := enabled[tenantID]; !ok {
+			return false
+		}
+	}
+
+	if len(disabled) > 0 {
+		if _, ok := disabled[tenantID]; ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// copySingleBlock copies every file within a single TSDB block to the destination bucket.
+// Files other than meta.json are copied by a worker pool (cfg.fileConcurrencyPerBlock) so a
+// large index file doesn't stall the rest of the block; meta.json is only uploaded once every
+// other file has copied successfully, since its presence signals to Cortex that the block
+// upload has finished. Each file copy is retried with backoff and checksum-verified, so the
+// copy is safe to simply rerun after a partial failure: already-copied files are re-uploaded,
+// which is wasteful but not unsafe.
+func copySingleBlock(ctx context.Context, tenantID string, blockID ulid.ULID, srcBkt, destBkt objstore.Bucket, cfg config, m *metrics) error {
+	paths, err := listPrefix(ctx, srcBkt, tenantID+delim+blockID.String(), true)
+	if err != nil {
+		return errors.Wrapf(err, "copySingleBlock: failed to list block files for %v/%v", tenantID, blockID.String())
+	}
+
+	var metaPath string
+	files := paths[:0]
+	for _, p := range paths {
+		if p == block.MetaFilename {
+			metaPath = p
+			continue
+		}
+		files = append(files, p)
+	}
+
+	fileConcurrency := cfg.fileConcurrencyPerBlock
+	if fileConcurrency <= 0 {
+		fileConcurrency = 1
+	}
+
+	err = concurrency.ForEachJob(ctx, len(files), fileConcurrency, func(ctx context.Context, idx int) error {
+		fullPath := tenantID + delim + blockID.String() + delim + files[idx]
+
+		if err := copyBlockFileWithRetry(ctx, srcBkt, destBkt, fullPath, fullPath, cfg, m); err != nil {
+			return errors.Wrapf(err, "copySingleBlock: failed to copy %v", fullPath)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if metaPath != "" {
+		fullPath := tenantID + delim + blockID.String() + delim + metaPath
+		if err := copyBlockFileWithRetry(ctx, srcBkt, destBkt, fullPath, fullPath, cfg, m); err != nil {
+			return errors.Wrapf(err, "copySingleBlock: failed to copy %v", fullPath)
+		}
+	}
+
+	return nil
+}
+
+// copyBlockFileWithRetry copies a single block file, retrying with exponential backoff on
+// retryable errors (context cancellation is not retried).
+func copyBlockFileWithRetry(ctx context.Context, srcBkt, destBkt objstore.Bucket, srcName, destName string, cfg config, m *metrics) error {
+	boff := backoff.New(ctx, backoff.Config{
+		MinBackoff: cfg.fileCopyMinBackoff,
+		MaxBackoff: cfg.fileCopyMaxBackoff,
+		// backoff counts the first attempt too, and treats 0 as unlimited.
+		MaxRetries: cfg.fileCopyMaxRetries + 1,
+	})
+
+	var lastErr error
+	for boff.Ongoing() {
+		start := time.Now()
+
+		size, err := copyBlockFileOnce(ctx, srcBkt, destBkt, srcName, destName, cfg.checksumVerifyMaxBytes, cfg.blockCopyBufferSizeBytes)
+		if err == nil {
+			m.fileCopyDuration.Observe(time.Since(start).Seconds())
+			m.fileCopyBytes.Observe(float64(size))
+			return nil
+		}
+
+		lastErr = err
+		if ctx.Err() != nil {
+			return lastErr
+		}
+		if !isRetryableCopyError(err) {
+			return lastErr
+		}
+
+		boff.Wait()
+		if boff.Ongoing() {
+			m.fileCopyRetries.Inc()
+		}
+	}
+
+	if lastErr != nil {
+		return lastErr
+	}
+	return boff.Err()
+}
+
+// isRetryableCopyError reports whether err is the kind of transient failure worth retrying
+// with backoff, as opposed to a permanent one (permission denied, not found, malformed
+// request, ...) that would just burn through fileCopyMaxRetries for nothing. Context
+// cancellation/deadline is handled separately by the caller and is never classified as
+// retryable here.
+//
+// GCS and gRPC errors carry a structured status code we can check directly. The S3, Azure,
+// Swift and filesystem backends objstore also supports don't expose a common error type for
+// this binary to type-assert against, so for those we fall back to a network-level check
+// (timeouts, connection resets) and a substring match against the transient statuses/phrases
+// those SDKs format into their error messages, so a copy to a non-GCS destination still gets
+// retry benefit from a throttled or momentarily unavailable backend.
+func isRetryableCopyError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusTooManyRequests || apiErr.Code >= http.StatusInternalServerError
+	}
+
+	if st, ok := status.FromError(errors.Cause(err)); ok {
+		switch st.Code() {
+		case codes.ResourceExhausted, codes.Unavailable, codes.Internal, codes.Aborted:
+			return true
+		default:
+			return false
+		}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, substr := range retryableCopyErrorSubstrings {
+		if strings.Contains(msg, substr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// retryableCopyErrorSubstrings are fragments of the error messages the S3, Azure and Swift
+// SDKs objstore wraps tend to format for transient, server-side failures (rate limiting,
+// unavailability, internal errors), since those backends don't expose a common structured
+// error type the way googleapi.Error/grpc/status do for GCS.
+var retryableCopyErrorSubstrings = []string{
+	"slow down",
+	"too many requests",
+	"request timeout",
+	"requesttimeout",
+	"internal error",
+	"internalerror",
+	"service unavailable",
+	"serviceunavailable",
+	"connection reset",
+	"rate exceeded",
+	"throttl",
+}
+
+// copyBlockFileOnce performs a single copy attempt, preferring a server-side copy hook when
+// the destination supports one, and otherwise streaming the object while computing its
+// CRC32C checksum. For objects up to checksumVerifyMaxBytes, the destination object is
+// re-read immediately after upload and its checksum compared against the source's, to catch
+// corruption a plain size check would miss; larger objects only get the (much cheaper) size
+// check, since re-reading a multi-GB segment file purely to verify it isn't worth the cost.
+//
+// A server-side copy is verified the same way: the copier itself is expected to check a
+// provider-native checksum where it can (see gcs.go's verifyRewriteChecksum), but since
+// serverSideCopier is a generic interface spanning any provider, copyBlockFileOnce also
+// compares the source and destination sizes after the fact as a backend-agnostic backstop.
+func copyBlockFileOnce(ctx context.Context, srcBkt, destBkt objstore.Bucket, srcName, destName string, checksumVerifyMaxBytes, bufferSizeBytes int64) (int64, error) {
+	if copier, ok := destBkt.(serverSideCopier); ok {
+		err := copier.ServerSideCopy(ctx, srcBkt, srcName, destName)
+		if err == nil {
+			srcAttrs, err := srcBkt.Attributes(ctx, srcName)
+			if err != nil {
+				return 0, errors.Wrapf(err, "failed to read attributes of %v after server-side copy", srcName)
+			}
+			destAttrs, err := destBkt.Attributes(ctx, destName)
+			if err != nil {
+				return 0, errors.Wrapf(err, "failed to read attributes of %v after server-side copy", destName)
+			}
+			if srcAttrs.Size != destAttrs.Size {
+				return 0, errors.Errorf("server-side copy of %v to %v failed verification: size %d in source but %d in destination", srcName, destName, srcAttrs.Size, destAttrs.Size)
+			}
+			return destAttrs.Size, nil
+		}
+		if !errors.Is(err, errServerSideCopyUnsupported) {
+			return 0, err
+		}
+		// Fall through to the streaming copy below.
+	}
+
+	srcAttrs, err := srcBkt.Attributes(ctx, srcName)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to read attributes of %v", srcName)
+	}
+
+	r, err := srcBkt.Get(ctx, srcName)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to read %v", srcName)
+	}
+
+	bufSize := bufferSizeBytes
+	if bufSize <= 0 {
+		bufSize = defaultBlockCopyBufferSizeBytes
+	}
+
+	srcChecksum := crc32.New(crc32.MakeTable(crc32cPolynomial))
+	tee := io.TeeReader(bufio.NewReaderSize(r, int(bufSize)), srcChecksum)
+
+	err = destBkt.Upload(ctx, destName, tee)
+	closeErr := r.Close()
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to upload %v", destName)
+	}
+	if closeErr != nil {
+		return 0, errors.Wrapf(closeErr, "failed to close reader for %v", srcName)
+	}
+
+	if checksumVerifyMaxBytes <= 0 || srcAttrs.Size <= checksumVerifyMaxBytes {
+		if err := verifyDestinationChecksum(ctx, destBkt, destName, srcChecksum.Sum32()); err != nil {
+			return 0, err
+		}
+	} else {
+		destAttrs, err := destBkt.Attributes(ctx, destName)
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to read attributes of %v after copy", destName)
+		}
+		if srcAttrs.Size != destAttrs.Size {
+			return 0, errors.Errorf("copy of %v to %v failed verification: size %d in source but %d in destination", srcName, destName, srcAttrs.Size, destAttrs.Size)
+		}
+	}
+
+	return srcAttrs.Size, nil
+}
+
+func verifyDestinationChecksum(ctx context.Context, destBkt objstore.Bucket, destName string, wantCRC32C uint32) error {
+	r, err := destBkt.Get(ctx, destName)
+	if err != nil {
+		return errors.Wrapf(err, "failed to re-read %v for checksum verification", destName)
+	}
+	defer r.Close()
+
+	h := crc32.New(crc32.MakeTable(crc32cPolynomial))
+	if _, err := io.Copy(h, r); err != nil {
+		return errors.Wrapf(err, "failed to read %v for checksum verification", destName)
+	}
+
+	if h.Sum32() != wantCRC32C {
+		return errors.Errorf("checksum mismatch for %v after copy", destName)
+	}
+
+	return nil
+}
+
+// copyObject copies a single object from srcBkt to destBkt. If destBkt exposes a
+// serverSideCopier, that's preferred; otherwise the object is streamed through this process
+// via Get/Upload.
+func copyObject(ctx context.Context, srcBkt, destBkt objstore.Bucket, srcName, destName string) error {
+	if copier, ok := destBkt.(serverSideCopier); ok {
+		err := copier.ServerSideCopy(ctx, srcBkt, srcName, destName)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, errServerSideCopyUnsupported) {
+			return err
+		}
+		// Fall through to the streaming copy below.
+	}
+
+	r, err := srcBkt.Get(ctx, srcName)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read %v", srcName)
+	}
+	defer r.Close()
+
+	if err := destBkt.Upload(ctx, destName, bufio.NewReaderSize(r, defaultBlockCopyBufferSizeBytes)); err != nil {
+		return errors.Wrapf(err, "failed to upload %v", destName)
+	}
+
+	return nil
+}
+
+// errServerSideCopyUnsupported is returned by a serverSideCopier when it cannot service a
+// particular copy (e.g. the source isn't the same concrete bucket type), signalling copyObject
+// to fall back to the generic streaming path.
+var errServerSideCopyUnsupported = errors.New("server-side copy not supported for this source/destination pair")
+
+func uploadCopiedMarkerFile(ctx context.Context, bkt objstore.Bucket, tenantID string, blockID ulid.ULID, targetBucketName string) error {
+	name := tenantID + delim + CopiedToBucketMarkFilename(blockID, targetBucketName)
+
+	return errors.Wrap(bkt.Upload(ctx, name, strings.NewReader("")), "uploadCopiedMarkerFile")
+}
+
+func loadMetaJSONFile(ctx context.Context, bkt objstore.Bucket, tenantID string, blockID ulid.ULID) (metadata.Meta, error) {
+	name := tenantID + delim + blockID.String() + delim + block.MetaFilename
+
+	r, err := bkt.Get(ctx, name)
+	if err != nil {
+		return metadata.Meta{}, errors.Wrapf(err, "failed to read %v", name)
+	}
+
+	var m metadata.Meta
+
+	dec := json.NewDecoder(r)
+	err = dec.Decode(&m)
+	closeErr := r.Close() // do this before any return.
+
+	if err != nil {
+		return metadata.Meta{}, errors.Wrapf(err, "read %v", name)
+	}
+	if closeErr != nil {
+		return metadata.Meta{}, errors.Wrapf(err, "close reader for %v", name)
+	}
+
+	return m, nil
+}
+
+func listTenants(ctx context.Context, bkt objstore.Bucket) ([]string, error) {
+	users, err := listPrefix(ctx, bkt, "", false)
+	if err != nil {
+		return nil, err
+	}
+
+	trimDelimSuffix(users)
+
+	return users, nil
+}
+
+func listBlocksForTenant(ctx context.Context, bkt objstore.Bucket, tenantID string) ([]ulid.ULID, error) {
+	items, err := listPrefix(ctx, bkt, tenantID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	trimDelimSuffix(items)
+
+	blocks := make([]ulid.ULID, 0, len(items))
+
+	for _, b := range items {
+		if id, ok := block.IsBlockDir(b); ok {
+			blocks = append(blocks, id)
+		}
+	}
+
+	return blocks, nil
+}
+
+// Each block can have multiple markers. This struct combines them together into single struct.
+type blockMarkers struct {
+	deletion bool
+	copied   bool
+}
+
+func listBlockMarkersForTenant(ctx context.Context, bkt objstore.Bucket, tenantID string, destinationBucket string) (map[ulid.ULID]blockMarkers, error) {
+	markers, err := listPrefix(ctx, bkt, tenantID+delim+bucketindex.MarkersPathname, false)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[ulid.ULID]blockMarkers{}
+
+	for _, m := range markers {
+		if id, ok := bucketindex.IsBlockDeletionMarkFilename(m); ok {
+			bm := result[id]
+			bm.deletion = true
+			result[id] = bm
+		}
+
+		if ok, id, targetBucket := IsCopiedToBucketMarkFilename(m); ok && targetBucket == destinationBucket {
+			bm := result[id]
+			bm.copied = true
+			result[id] = bm
+		}
+	}
+
+	return result, nil
+}
+
+func trimDelimSuffix(items []string) {
+	for ix := range items {
+		items[ix] = strings.TrimSuffix(items[ix], delim)
+	}
+}
+
+// listPrefix lists the objects (recursive=true) or the immediate entries (recursive=false,
+// "directories" included) below prefix, returning names relative to prefix.
+func listPrefix(ctx context.Context, bkt objstore.Bucket, prefix string, recursive bool) ([]string, error) {
+	if len(prefix) > 0 && !strings.HasSuffix(prefix, delim) {
+		prefix += delim
+	}
+
+	var opts []objstore.IterOption
+	if recursive {
+		opts = append(opts, objstore.WithRecursive())
+	}
+
+	var result []string
+
+	err := bkt.Iter(ctx, prefix, func(name string) error {
+		result = append(result, strings.TrimPrefix(name, prefix))
+		return nil
+	}, opts...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "listPrefix: error listing %v", prefix)
+	}
+
+	return result, nil
+}
+
+const CopiedMarkFilename = "copied"
+
+// CopiedToBucketMarkFilename returns the path of marker file signalling that block was copied to given destination bucket.
+// Returned path is relative to the tenant's bucket location.
+func CopiedToBucketMarkFilename(blockID ulid.ULID, targetBucket string) string {
+	// eg markers/01EZED0X3YZMNJ3NHGMJJKMHCR-copied-target-bucket
+	return fmt.Sprintf("%s/%s-%s-%s", bucketindex.MarkersPathname, blockID.String(), CopiedMarkFilename, targetBucket)
+}
+
+// IsCopiedToBucketMarkFilename returns whether the input filename matches the expected pattern
+// of copied markers stored in markers location.
+// Target bucket is part of the mark filename, and is returned as 3rd return value.
+func IsCopiedToBucketMarkFilename(name string) (bool, ulid.ULID, string) {
+	parts := strings.SplitN(name, "-", 3)
+	if len(parts) != 3 {
+		return false, ulid.ULID{}, ""
+	}
+
+	// Ensure the 2nd part matches the block copy mark filename.
+	if parts[1] != CopiedMarkFilename {
+		return false, ulid.ULID{}, ""
+	}
+
+	// Ensure the 1st part is a valid block ID.
+	id, err := ulid.Parse(filepath.Base(parts[0]))
+	if err != nil {
+		return false, ulid.ULID{}, ""
+	}
+
+	return true, id, parts[2]
+}
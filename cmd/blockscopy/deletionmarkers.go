@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/storage/tsdb/bucketindex"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+// syncDeletionMarkersForTenant copies deletion-mark.json markers that exist in the source
+// bucket but are missing (or newer) in the destination, and no-compact-mark.json markers for
+// blocks already copied to the destination, so a block marked for deletion or excluded from
+// compaction after it was already copied doesn't drift out of sync at the destination forever.
+// Marks younger than minAge are skipped, to avoid propagating a mark that's still in flux. It
+// returns the deletion marks newly synced this call, for the caller to fold into the
+// destination bucket index without a separate read.
+func syncDeletionMarkersForTenant(ctx context.Context, logger log.Logger, srcBkt, destBkt objstore.Bucket, tenantID string, blocks []ulid.ULID, minAge time.Duration, m *metrics) ([]*bucketindex.BlockDeletionMark, error) {
+	synced, err := syncBlockDeletionMarks(ctx, logger, srcBkt, destBkt, tenantID, minAge, m)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syncBlockNoCompactMarks(ctx, logger, srcBkt, destBkt, tenantID, blocks, minAge, m); err != nil {
+		return nil, err
+	}
+
+	return synced, nil
+}
+
+// syncBlockDeletionMarks mirrors deletion-mark.json markers. Cortex's compactor maintains these
+// under the tenant's shared markers/ directory precisely so they're cheap to list without
+// scanning every block, which is why they're discovered there rather than per-block.
+func syncBlockDeletionMarks(ctx context.Context, logger log.Logger, srcBkt, destBkt objstore.Bucket, tenantID string, minAge time.Duration, m *metrics) ([]*bucketindex.BlockDeletionMark, error) {
+	markersDir := tenantID + delim + bucketindex.MarkersPathname
+
+	names, err := listPrefix(ctx, srcBkt, markersDir, false)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list markers for tenant %v", tenantID)
+	}
+
+	var synced []*bucketindex.BlockDeletionMark
+
+	for _, name := range names {
+		blockID, ok := bucketindex.IsBlockDeletionMarkFilename(name)
+		if !ok {
+			continue
+		}
+
+		logger := log.With(logger, "block", blockID, "marker", "deletion-mark")
+		markerPath := markersDir + delim + name
+
+		didSync, err := syncSingleMarker(ctx, logger, srcBkt, destBkt, tenantID, blockID, markerPath, minAge, m)
+		if err != nil {
+			return nil, err
+		}
+		if !didSync {
+			continue
+		}
+
+		mark, err := bucketindex.ReadDeletionMark(ctx, destBkt, tenantID, blockID.String())
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read back synced deletion mark for block %v", blockID)
+		}
+		synced = append(synced, mark)
+	}
+
+	return synced, nil
+}
+
+// syncBlockNoCompactMarks mirrors no-compact-mark.json markers. Unlike deletion marks, Cortex
+// writes these only inside the block's own directory (<tenant>/<block>/no-compact-mark.json),
+// never into the shared markers/ index, so they have to be looked up per block rather than
+// discovered by listing markers/. The bucket index has no field for no-compact marks, so
+// there's nothing for the caller to fold in here.
+func syncBlockNoCompactMarks(ctx context.Context, logger log.Logger, srcBkt, destBkt objstore.Bucket, tenantID string, blocks []ulid.ULID, minAge time.Duration, m *metrics) error {
+	for _, blockID := range blocks {
+		markerPath := tenantID + delim + blockID.String() + delim + metadata.NoCompactMarkFilename
+
+		exists, err := srcBkt.Exists(ctx, markerPath)
+		if err != nil {
+			return errors.Wrapf(err, "failed to check no-compact mark %v", markerPath)
+		}
+		if !exists {
+			continue
+		}
+
+		logger := log.With(logger, "block", blockID, "marker", "no-compact-mark")
+
+		if _, err := syncSingleMarker(ctx, logger, srcBkt, destBkt, tenantID, blockID, markerPath, minAge, m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// syncSingleMarker copies a single marker file at markerPath from srcBkt to destBkt, skipping
+// blocks not yet present at the destination, markers younger than minAge, and markers already
+// up to date at the destination. It reports whether the marker was actually copied.
+func syncSingleMarker(ctx context.Context, logger log.Logger, srcBkt, destBkt objstore.Bucket, tenantID string, blockID ulid.ULID, markerPath string, minAge time.Duration, m *metrics) (bool, error) {
+	if destBlockExists, err := destBkt.Exists(ctx, tenantID+delim+blockID.String()+delim+block.MetaFilename); err != nil {
+		return false, errors.Wrapf(err, "failed to check destination block %v/%v", tenantID, blockID)
+	} else if !destBlockExists {
+		level.Debug(logger).Log("msg", "skipping marker, block not present in destination bucket")
+		m.deletionMarksSkipped.Inc()
+		return false, nil
+	}
+
+	srcAttrs, err := srcBkt.Attributes(ctx, markerPath)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to read attributes of %v", markerPath)
+	}
+
+	if minAge > 0 && time.Since(srcAttrs.LastModified) < minAge {
+		level.Debug(logger).Log("msg", "skipping marker, not old enough yet", "age", time.Since(srcAttrs.LastModified))
+		m.deletionMarksSkipped.Inc()
+		return false, nil
+	}
+
+	if destAttrs, err := destBkt.Attributes(ctx, markerPath); err == nil {
+		if !srcAttrs.LastModified.After(destAttrs.LastModified) {
+			return false, nil
+		}
+	} else if !destBkt.IsObjNotFoundErr(err) {
+		return false, errors.Wrapf(err, "failed to read attributes of existing marker %v in destination bucket", markerPath)
+	}
+
+	if err := copyObject(ctx, srcBkt, destBkt, markerPath, markerPath); err != nil {
+		return false, errors.Wrapf(err, "failed to copy marker %v", markerPath)
+	}
+
+	level.Info(logger).Log("msg", "synced marker to destination bucket")
+	m.deletionMarksSynced.Inc()
+	return true, nil
+}
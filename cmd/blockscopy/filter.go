@@ -0,0 +1,215 @@
+package main
+
+import (
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+// blockFilter decides which blocks copyBlocks should operate on, beyond the basic
+// already-copied/marked-for-deletion checks. All meta.json-derived checks are evaluated
+// together against a single load of the block's meta.json (see needsMeta/allows), so a block
+// that fails several of them still only costs one meta.json download.
+type blockFilter struct {
+	minBlockDuration time.Duration
+
+	minCompactionLevel int
+	maxCompactionLevel int
+
+	// minBlockTime and maxBlockTime are Unix millis, matching metadata.Meta.MinTime/MaxTime. 0 means unset.
+	minBlockTime int64
+	maxBlockTime int64
+
+	requireExternalLabels map[string]string
+
+	shardID    int
+	shardTotal int
+}
+
+func (f blockFilter) needsMeta() bool {
+	return f.minBlockDuration > 0 ||
+		f.minCompactionLevel > 0 ||
+		f.maxCompactionLevel > 0 ||
+		f.minBlockTime > 0 ||
+		f.maxBlockTime > 0 ||
+		len(f.requireExternalLabels) > 0
+}
+
+// shardAllows reports whether blockID belongs to this filter's shard. It's cheap enough to
+// check before fetching meta.json, so replicas running the same config against the same
+// buckets can split the work without any coordination beyond agreeing on shardTotal.
+func (f blockFilter) shardAllows(blockID ulid.ULID) bool {
+	if f.shardTotal <= 1 {
+		return true
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write(blockID[:])
+
+	return int(h.Sum32()%uint32(f.shardTotal)) == f.shardID
+}
+
+// allows evaluates every meta.json-derived check against an already-loaded meta.json,
+// returning false and a human-readable reason for the first one that fails.
+func (f blockFilter) allows(meta metadata.Meta) (bool, string) {
+	if f.minBlockDuration > 0 {
+		blockDuration := time.Millisecond * time.Duration(meta.MaxTime-meta.MinTime)
+		if blockDuration < f.minBlockDuration {
+			return false, "block duration is smaller than minimum duration"
+		}
+	}
+
+	if f.minCompactionLevel > 0 && meta.Compaction.Level < f.minCompactionLevel {
+		return false, "compaction level is below minimum"
+	}
+
+	if f.maxCompactionLevel > 0 && meta.Compaction.Level > f.maxCompactionLevel {
+		return false, "compaction level is above maximum"
+	}
+
+	if f.minBlockTime > 0 && meta.MinTime < f.minBlockTime {
+		return false, "block min time is before the configured minimum"
+	}
+
+	if f.maxBlockTime > 0 && meta.MaxTime > f.maxBlockTime {
+		return false, "block max time is after the configured maximum"
+	}
+
+	for k, v := range f.requireExternalLabels {
+		if meta.Thanos.Labels[k] != v {
+			return false, "required external label " + k + "=" + v + " is missing or doesn't match"
+		}
+	}
+
+	return true, ""
+}
+
+// buildBlockFilter translates the raw flag values in cfg into a blockFilter, parsing the
+// block-time and shard specs and validating the external-labels list.
+func buildBlockFilter(cfg config) (blockFilter, error) {
+	f := blockFilter{
+		minBlockDuration:   cfg.minBlockDuration,
+		minCompactionLevel: cfg.minCompactionLevel,
+		maxCompactionLevel: cfg.maxCompactionLevel,
+	}
+
+	now := time.Now()
+
+	if cfg.minBlockTimeStr != "" {
+		t, err := parseFlexibleTime(cfg.minBlockTimeStr, now)
+		if err != nil {
+			return blockFilter{}, errors.Wrap(err, "invalid -min-block-time")
+		}
+		f.minBlockTime = timeToMillis(t)
+	}
+
+	if cfg.maxBlockTimeStr != "" {
+		t, err := parseFlexibleTime(cfg.maxBlockTimeStr, now)
+		if err != nil {
+			return blockFilter{}, errors.Wrap(err, "invalid -max-block-time")
+		}
+		f.maxBlockTime = timeToMillis(t)
+	}
+
+	if len(cfg.requireExternalLabels) > 0 {
+		labels, err := parseExternalLabels(cfg.requireExternalLabels)
+		if err != nil {
+			return blockFilter{}, errors.Wrap(err, "invalid -require-external-labels")
+		}
+		f.requireExternalLabels = labels
+	}
+
+	if cfg.shardSpec != "" {
+		id, total, err := parseShardSpec(cfg.shardSpec)
+		if err != nil {
+			return blockFilter{}, errors.Wrap(err, "invalid -shard-id")
+		}
+		f.shardID, f.shardTotal = id, total
+	}
+
+	return f, nil
+}
+
+func timeToMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// parseFlexibleTime parses an RFC3339 timestamp, or a "now-<duration>" / "now+<duration>"
+// expression relative to now (e.g. "now-30d"), using Prometheus' model.Duration so day/week
+// units are understood.
+func parseFlexibleTime(s string, now time.Time) (time.Time, error) {
+	if rel := strings.TrimPrefix(s, "now"); rel != s {
+		if rel == "" {
+			return now, nil
+		}
+
+		sign := rel[0]
+		if sign != '+' && sign != '-' {
+			return time.Time{}, errors.Errorf("expected \"now+<duration>\" or \"now-<duration>\", got %q", s)
+		}
+
+		d, err := model.ParseDuration(rel[1:])
+		if err != nil {
+			return time.Time{}, errors.Wrapf(err, "invalid duration in %q", s)
+		}
+
+		if sign == '-' {
+			return now.Add(-time.Duration(d)), nil
+		}
+		return now.Add(time.Duration(d)), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "expected RFC3339 timestamp or \"now-<duration>\", got %q", s)
+	}
+
+	return t, nil
+}
+
+// parseShardSpec parses a "<shardID>/<shardTotal>" spec, e.g. "2/8" for the third of eight
+// replicas (shardID is 0-based).
+func parseShardSpec(s string) (int, int, error) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, errors.Errorf("expected \"<shardID>/<shardTotal>\", got %q", s)
+	}
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "invalid shard ID %q", parts[0])
+	}
+
+	total, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "invalid shard total %q", parts[1])
+	}
+
+	if total <= 0 || id < 0 || id >= total {
+		return 0, 0, errors.Errorf("shard ID must be in [0,%d), got %q", total, s)
+	}
+
+	return id, total, nil
+}
+
+// parseExternalLabels parses a "k=v,k2=v2" list (already split on commas by
+// flagext.StringSliceCSV) into a map.
+func parseExternalLabels(kvs []string) (map[string]string, error) {
+	labels := make(map[string]string, len(kvs))
+
+	for _, kv := range kvs {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.Errorf("expected \"key=value\", got %q", kv)
+		}
+		labels[parts[0]] = parts[1]
+	}
+
+	return labels, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/storage/tsdb/bucketindex"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+// resolveTenantBlocks returns the tenant's block IDs, preferring the Cortex bucket-index
+// (bucket-index.json.gz) over a full top-level bucket listing. It falls back to
+// listBlocksForTenant when the index is missing or older than maxStaleness, since a stale
+// index could hide recently uploaded blocks.
+func resolveTenantBlocks(ctx context.Context, logger log.Logger, bkt objstore.Bucket, tenantID string, maxStaleness time.Duration, m *metrics) ([]ulid.ULID, error) {
+	idx, err := bucketindex.ReadIndex(ctx, bkt, tenantID, nil, logger)
+	if err != nil {
+		level.Warn(logger).Log("msg", "falling back to full bucket listing, failed to read bucket index", "err", err)
+		m.bucketIndexFallbacks.WithLabelValues("read-error").Inc()
+		return listBlocksForTenant(ctx, bkt, tenantID)
+	}
+
+	age := time.Since(time.Unix(idx.UpdatedAt, 0))
+	if maxStaleness > 0 && age > maxStaleness {
+		level.Warn(logger).Log("msg", "falling back to full bucket listing, bucket index is stale", "age", age, "maxStaleness", maxStaleness)
+		m.bucketIndexFallbacks.WithLabelValues("stale").Inc()
+		return listBlocksForTenant(ctx, bkt, tenantID)
+	}
+
+	blocks := make([]ulid.ULID, 0, len(idx.Blocks))
+	for _, b := range idx.Blocks {
+		blocks = append(blocks, b.ID)
+	}
+
+	m.bucketIndexUsed.Inc()
+	return blocks, nil
+}
+
+// updateIndexMaxAttempts bounds the optimistic-concurrency retry loop in
+// updateDestinationBucketIndex, so two replicas (e.g. different -shard-id copiers) racing to
+// update the same tenant's index don't retry forever if they keep colliding.
+const updateIndexMaxAttempts = 5
+
+// updateDestinationBucketIndex incrementally updates the destination tenant's
+// bucket-index.json.gz with the blocks copied and deletion marks synced this cycle. It reads
+// the existing index (if any) and appends to it, rather than re-listing every block in the
+// destination bucket and re-fetching every meta.json on every cycle, which would cost just as
+// much as the full listing resolveTenantBlocks was introduced to avoid on the read side.
+//
+// objstore doesn't expose a generation/ETag precondition across every backend it supports, so
+// there's no way to make the read-merge-write a single atomic operation. Instead, the index is
+// re-read immediately before the write and compared against the copy just merged from: if
+// another replica wrote in between, the merge is discarded and retried against the newer index,
+// up to updateIndexMaxAttempts times. This narrows the race window a concurrent writer can
+// exploit down to the gap between that re-read and the write itself, instead of the whole
+// read-merge-write cycle.
+func updateDestinationBucketIndex(ctx context.Context, logger log.Logger, destBkt objstore.Bucket, tenantID string, newBlocks []*metadata.Meta, newDeletionMarks []*bucketindex.BlockDeletionMark) error {
+	if len(newBlocks) == 0 && len(newDeletionMarks) == 0 {
+		return nil
+	}
+
+	for attempt := 1; ; attempt++ {
+		idx, err := readDestinationBucketIndex(ctx, destBkt, tenantID, logger)
+		if err != nil {
+			return err
+		}
+		baseUpdatedAt := idx.UpdatedAt
+
+		mergeBucketIndex(idx, newBlocks, newDeletionMarks)
+
+		current, err := readDestinationBucketIndex(ctx, destBkt, tenantID, logger)
+		if err != nil {
+			return err
+		}
+		if current.UpdatedAt != baseUpdatedAt {
+			if attempt >= updateIndexMaxAttempts {
+				return errors.Errorf("failed to update destination bucket index for tenant %v after %d attempts: a concurrent writer keeps winning the race", tenantID, updateIndexMaxAttempts)
+			}
+			level.Warn(logger).Log("msg", "destination bucket index changed concurrently while merging, retrying", "tenant", tenantID, "attempt", attempt)
+			continue
+		}
+
+		idx.UpdatedAt = time.Now().Unix()
+
+		return errors.Wrap(bucketindex.WriteIndex(ctx, destBkt, tenantID, nil, idx), "failed to write destination bucket index")
+	}
+}
+
+// readDestinationBucketIndex reads the destination tenant's bucket index, returning a fresh,
+// empty index (rather than an error) if one doesn't exist yet.
+func readDestinationBucketIndex(ctx context.Context, destBkt objstore.Bucket, tenantID string, logger log.Logger) (*bucketindex.Index, error) {
+	idx, err := bucketindex.ReadIndex(ctx, destBkt, tenantID, nil, logger)
+	if errors.Is(err, bucketindex.ErrIndexNotFound) {
+		return &bucketindex.Index{Version: bucketindex.IndexVersion1}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read existing destination bucket index for tenant %v", tenantID)
+	}
+	return idx, nil
+}
+
+// mergeBucketIndex appends newBlocks and newDeletionMarks to idx in place, skipping any that
+// are already present.
+func mergeBucketIndex(idx *bucketindex.Index, newBlocks []*metadata.Meta, newDeletionMarks []*bucketindex.BlockDeletionMark) {
+	existingBlocks := make(map[ulid.ULID]struct{}, len(idx.Blocks))
+	for _, b := range idx.Blocks {
+		existingBlocks[b.ID] = struct{}{}
+	}
+
+	for _, meta := range newBlocks {
+		if _, ok := existingBlocks[meta.ULID]; ok {
+			continue
+		}
+		idx.Blocks = append(idx.Blocks, bucketindex.BlockFromThanosMeta(meta))
+	}
+
+	existingMarks := make(map[ulid.ULID]struct{}, len(idx.BlockDeletionMarks))
+	for _, mark := range idx.BlockDeletionMarks {
+		existingMarks[mark.ID] = struct{}{}
+	}
+
+	for _, mark := range newDeletionMarks {
+		if _, ok := existingMarks[mark.ID]; ok {
+			continue
+		}
+		idx.BlockDeletionMarks = append(idx.BlockDeletionMarks, mark)
+	}
+}
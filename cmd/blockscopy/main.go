@@ -2,54 +2,61 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
 
-	"cloud.google.com/go/storage"
-	"github.com/cortexproject/cortex/pkg/storage/tsdb/bucketindex"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
-	"github.com/grafana/dskit/concurrency"
 	"github.com/grafana/dskit/flagext"
-	"github.com/oklog/ulid"
-	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/thanos-io/thanos/pkg/block"
-	"github.com/thanos-io/thanos/pkg/block/metadata"
-	"google.golang.org/api/iterator"
-)
-
-const (
-	delim = "/" // Used by Cortex to delimit tenants and blocks, and objects within blocks.
 )
 
 type config struct {
-	sourceBucket      string
-	destBucket        string
-	minBlockDuration  time.Duration
-	tenantConcurrency int
-	blocksConcurrency int
-	copyPeriod        time.Duration
-	enabledUsers      flagext.StringSliceCSV
-	disabledUsers     flagext.StringSliceCSV
-	dryRun            bool
+	sourceBucketConfigFile string
+	destBucketConfigFile   string
+	minBlockDuration       time.Duration
+	tenantConcurrency      int
+	blocksConcurrency      int
+	copyPeriod             time.Duration
+	enabledUsers           flagext.StringSliceCSV
+	disabledUsers          flagext.StringSliceCSV
+	dryRun                 bool
+
+	syncDeletionMarkers bool
+	deletionMarkMinAge  time.Duration
+
+	bucketIndexMaxStaleness time.Duration
+
+	fileConcurrencyPerBlock  int
+	fileCopyMaxRetries       int
+	fileCopyMinBackoff       time.Duration
+	fileCopyMaxBackoff       time.Duration
+	checksumVerifyMaxBytes   int64
+	blockCopyBufferSizeBytes int64
+
+	gcsChunkedRewriteThresholdBytes int64
+
+	minCompactionLevel    int
+	maxCompactionLevel    int
+	minBlockTimeStr       string
+	maxBlockTimeStr       string
+	requireExternalLabels flagext.StringSliceCSV
+	shardSpec             string
 
 	httpListen string
 }
 
 func (c *config) RegisterFlags(f *flag.FlagSet) {
-	f.StringVar(&c.sourceBucket, "source-bucket", "", "Source GCS bucket with blocks.")
-	f.StringVar(&c.destBucket, "destination-bucket", "", "Destination GCS bucket with blocks.")
+	f.StringVar(&c.sourceBucketConfigFile, "source-bucket-config-file", "", "Path to YAML file with the objstore config (type/config, as used by Thanos and Cortex) of the source bucket.")
+	f.StringVar(&c.destBucketConfigFile, "destination-bucket-config-file", "", "Path to YAML file with the objstore config (type/config, as used by Thanos and Cortex) of the destination bucket.")
 	f.DurationVar(&c.minBlockDuration, "min-block-duration", 24*time.Hour, "If non-zero, ignore blocks that cover block range smaller than this.")
 	f.IntVar(&c.tenantConcurrency, "tenant-concurrency", 5, "How many tenants to process at once.")
 	f.IntVar(&c.blocksConcurrency, "block-concurrency", 5, "How many blocks to copy at once per tenant.")
@@ -58,6 +65,22 @@ func (c *config) RegisterFlags(f *flag.FlagSet) {
 	f.Var(&c.disabledUsers, "disabled-users", "If not empty, blocks for these users are not copied.")
 	f.StringVar(&c.httpListen, "http-listen-address", ":8080", "HTTP listen address.")
 	f.BoolVar(&c.dryRun, "dry-run", false, "Don't perform copy, only log what would happen.")
+	f.BoolVar(&c.syncDeletionMarkers, "sync-deletion-markers", false, "Mirror deletion-mark.json and no-compact-mark.json markers from the source bucket to the destination bucket, for blocks already present at the destination.")
+	f.DurationVar(&c.deletionMarkMinAge, "deletion-mark-min-age", time.Hour, "Minimum age of a deletion/no-compact marker in the source bucket before it's eligible to be synced to the destination. Avoids propagating a mark that's still in flux.")
+	f.DurationVar(&c.bucketIndexMaxStaleness, "bucket-index-max-staleness", 2*time.Hour, "Maximum age of the source tenant's bucket-index.json.gz before falling back to a full bucket listing.")
+	f.IntVar(&c.fileConcurrencyPerBlock, "file-concurrency-per-block", 4, "How many files of a single block to copy concurrently.")
+	f.IntVar(&c.fileCopyMaxRetries, "file-copy-max-retries", 3, "Maximum number of retries for a single file copy, on retryable errors.")
+	f.DurationVar(&c.fileCopyMinBackoff, "file-copy-min-backoff", time.Second, "Minimum backoff before retrying a failed file copy.")
+	f.DurationVar(&c.fileCopyMaxBackoff, "file-copy-max-backoff", 30*time.Second, "Maximum backoff before retrying a failed file copy.")
+	f.Int64Var(&c.checksumVerifyMaxBytes, "checksum-verify-max-bytes", 512*1024*1024, "Files up to this size are re-read from the destination after copy to verify their checksum. Larger files are only checked by size, since re-reading them would be too expensive. 0 disables the size limit, verifying every file.")
+	f.Int64Var(&c.blockCopyBufferSizeBytes, "block-copy-buffer-size-bytes", 16*1024*1024, "Buffer size used to stream a block file from the source bucket to the destination bucket when no server-side copy is available.")
+	f.Int64Var(&c.gcsChunkedRewriteThresholdBytes, "gcs-chunked-rewrite-threshold-bytes", 1*1024*1024*1024, "Objects at or above this size copied between two GCS buckets are rewritten in chunks, persisting the GCS rewrite token to a sidecar object so the copy can resume after an interruption instead of restarting from byte zero.")
+	f.IntVar(&c.minCompactionLevel, "min-compaction-level", 0, "If non-zero, ignore blocks with a compaction level below this.")
+	f.IntVar(&c.maxCompactionLevel, "max-compaction-level", 0, "If non-zero, ignore blocks with a compaction level above this.")
+	f.StringVar(&c.minBlockTimeStr, "min-block-time", "", "If set, ignore blocks whose MinTime is before this. Accepts an RFC3339 timestamp or \"now-<duration>\" (e.g. \"now-30d\").")
+	f.StringVar(&c.maxBlockTimeStr, "max-block-time", "", "If set, ignore blocks whose MaxTime is after this. Accepts an RFC3339 timestamp or \"now-<duration>\" (e.g. \"now-30d\").")
+	f.Var(&c.requireExternalLabels, "require-external-labels", "If not empty, only copy blocks whose Thanos external labels (meta.json \"thanos.labels\") match all of these \"key=value\" pairs.")
+	f.StringVar(&c.shardSpec, "shard-id", "", "If set, only copy blocks hashing to this shard, as \"<shardID>/<shardTotal>\" (e.g. \"2/8\"), so multiple copier replicas can run against the same config without overlapping.")
 }
 
 type metrics struct {
@@ -65,6 +88,16 @@ type metrics struct {
 	copyCyclesFailed    prometheus.Counter
 	blocksCopied        prometheus.Counter
 	blocksCopyFailed    prometheus.Counter
+
+	deletionMarksSynced  prometheus.Counter
+	deletionMarksSkipped prometheus.Counter
+
+	bucketIndexUsed      prometheus.Counter
+	bucketIndexFallbacks *prometheus.CounterVec
+
+	fileCopyDuration prometheus.Histogram
+	fileCopyBytes    prometheus.Histogram
+	fileCopyRetries  prometheus.Counter
 }
 
 func newMetrics(reg prometheus.Registerer) *metrics {
@@ -85,27 +118,82 @@ func newMetrics(reg prometheus.Registerer) *metrics {
 			Name: "cortex_blocks_copy_blocks_failed_total",
 			Help: "Number of blocks that failed to copy.",
 		}),
+		deletionMarksSynced: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_blocks_copy_deletion_marks_synced_total",
+			Help: "Number of deletion/no-compact markers synced from the source bucket to the destination bucket.",
+		}),
+		deletionMarksSkipped: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_blocks_copy_deletion_marks_skipped_total",
+			Help: "Number of deletion/no-compact markers not synced, because the block isn't at the destination yet or the marker isn't old enough.",
+		}),
+		bucketIndexUsed: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_blocks_copy_bucket_index_used_total",
+			Help: "Number of times the source tenant's bucket-index.json.gz was used instead of a full bucket listing.",
+		}),
+		bucketIndexFallbacks: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_blocks_copy_bucket_index_fallbacks_total",
+			Help: "Number of times blockscopy fell back to a full bucket listing instead of using the bucket index, by reason.",
+		}, []string{"reason"}),
+		fileCopyDuration: promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+			Name:    "cortex_blocks_copy_file_copy_duration_seconds",
+			Help:    "Time taken to copy a single block file to the destination bucket.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		fileCopyBytes: promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+			Name:    "cortex_blocks_copy_file_copy_bytes",
+			Help:    "Size of block files copied to the destination bucket.",
+			Buckets: prometheus.ExponentialBuckets(1024, 4, 12),
+		}),
+		fileCopyRetries: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_blocks_copy_file_copy_retries_total",
+			Help: "Number of retried block file copies.",
+		}),
 	}
 }
 
+// main dispatches to the "copy" subcommand (the default, for backwards compatibility) or the
+// "verify" subcommand, each with its own flag set.
 func main() {
+	args := os.Args[1:]
+
+	mode := "copy"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		mode = args[0]
+		args = args[1:]
+	}
+
+	switch mode {
+	case "copy":
+		mainCopy(args)
+	case "verify":
+		mainVerify(args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q: expected \"copy\" or \"verify\"\n", mode)
+		os.Exit(2)
+	}
+}
+
+func mainCopy(args []string) {
+	fs := flag.NewFlagSet("copy", flag.ExitOnError)
+
 	cfg := config{}
-	cfg.RegisterFlags(flag.CommandLine)
+	cfg.RegisterFlags(fs)
 
-	flag.Parse()
+	_ = fs.Parse(args)
 
 	logger := log.NewLogfmtLogger(os.Stdout)
 	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
 
-	if cfg.sourceBucket == "" || cfg.destBucket == "" || cfg.sourceBucket == cfg.destBucket {
-		level.Error(logger).Log("msg", "no source or destination bucket, or buckets are the same")
+	if cfg.sourceBucketConfigFile == "" || cfg.destBucketConfigFile == "" {
+		level.Error(logger).Log("msg", "no source or destination bucket config file given")
 		os.Exit(1)
 	}
 
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	m := newMetrics(prometheus.DefaultRegisterer)
+	reg := prometheus.DefaultRegisterer
+	m := newMetrics(reg)
 
 	go func() {
 		level.Info(logger).Log("msg", "HTTP server listening on "+cfg.httpListen)
@@ -117,7 +205,7 @@ func main() {
 		}
 	}()
 
-	success := runCopy(ctx, cfg, logger, m)
+	success := runCopy(ctx, cfg, logger, reg, m)
 	if cfg.copyPeriod <= 0 {
 		if success {
 			os.Exit(0)
@@ -131,14 +219,14 @@ func main() {
 	for ctx.Err() == nil {
 		select {
 		case <-t.C:
-			_ = runCopy(ctx, cfg, logger, m)
+			_ = runCopy(ctx, cfg, logger, reg, m)
 		case <-ctx.Done():
 		}
 	}
 }
 
-func runCopy(ctx context.Context, cfg config, logger log.Logger, m *metrics) bool {
-	err := copyBlocks(ctx, cfg, logger, m)
+func runCopy(ctx context.Context, cfg config, logger log.Logger, reg prometheus.Registerer, m *metrics) bool {
+	err := copyBlocks(ctx, cfg, logger, reg, m)
 	if err != nil {
 		m.copyCyclesFailed.Inc()
 		level.Error(logger).Log("msg", "failed to copy blocks", "err", err, "dryRun", cfg.dryRun)
@@ -149,336 +237,3 @@ func runCopy(ctx context.Context, cfg config, logger log.Logger, m *metrics) boo
 	level.Info(logger).Log("msg", "finished copying blocks", "dryRun", cfg.dryRun)
 	return true
 }
-
-func copyBlocks(ctx context.Context, cfg config, logger log.Logger, m *metrics) error {
-	enabledUsers := map[string]struct{}{}
-	disabledUsers := map[string]struct{}{}
-
-	for _, u := range cfg.enabledUsers {
-		enabledUsers[u] = struct{}{}
-	}
-	for _, u := range cfg.disabledUsers {
-		disabledUsers[u] = struct{}{}
-	}
-
-	client, err := storage.NewClient(ctx)
-	if err != nil {
-		return errors.Wrapf(err, "failed to create client")
-	}
-
-	sourceBucket := client.Bucket(cfg.sourceBucket)
-	destBucket := client.Bucket(cfg.destBucket)
-
-	tenants, err := listTenants(ctx, sourceBucket)
-	if err != nil {
-		return errors.Wrapf(err, "failed to list tenants")
-	}
-
-	return concurrency.ForEachUser(ctx, tenants, cfg.tenantConcurrency, func(ctx context.Context, tenantID string) error {
-		if !isAllowedUser(enabledUsers, disabledUsers, tenantID) {
-			return nil
-		}
-
-		logger := log.With(logger, "tenantID", tenantID)
-
-		blocks, err := listBlocksForTenant(ctx, sourceBucket, tenantID)
-		if err != nil {
-			level.Error(logger).Log("msg", "failed to list blocks for tenant", "err", err)
-			return errors.Wrapf(err, "failed to list blocks for tenant %v", tenantID)
-		}
-
-		markers, err := listBlockMarkersForTenant(ctx, sourceBucket, tenantID, cfg.destBucket)
-		if err != nil {
-			level.Error(logger).Log("msg", "failed to list blocks markers for tenant", "err", err)
-			return errors.Wrapf(err, "failed to list block markers for tenant %v", tenantID)
-		}
-
-		var blockIDs []string
-		for _, b := range blocks {
-			blockIDs = append(blockIDs, b.String())
-		}
-
-		// We use ForEachUser here to keep processing other blocks, if the block fails. We pass block IDs as "users".
-		return concurrency.ForEachUser(ctx, blockIDs, cfg.blocksConcurrency, func(ctx context.Context, blockIDStr string) error {
-			blockID, err := ulid.Parse(blockIDStr)
-			if err != nil {
-				return err
-			}
-
-			logger := log.With(logger, "block", blockID)
-
-			if markers[blockID].copied {
-				level.Debug(logger).Log("msg", "skipping block because it has been copied already")
-				return nil
-			}
-
-			if markers[blockID].deletion {
-				level.Debug(logger).Log("msg", "skipping block because it is marked for deletion")
-				return nil
-			}
-
-			if cfg.minBlockDuration > 0 {
-				meta, err := loadMetaJSONFile(ctx, sourceBucket, tenantID, blockID)
-				if err != nil {
-					level.Error(logger).Log("msg", "skipping block, failed to read meta.json file", "err", err)
-					return err
-				}
-
-				blockDuration := time.Millisecond * time.Duration(meta.MaxTime-meta.MinTime)
-				if blockDuration < cfg.minBlockDuration {
-					level.Debug(logger).Log("msg", "skipping block, block duration is smaller than minimum duration", "blockDuration", blockDuration, "minimumDuration", cfg.minBlockDuration)
-					return nil
-				}
-			}
-
-			if cfg.dryRun {
-				level.Info(logger).Log("msg", "would copy block, but skipping due to dry-run")
-				return nil
-			}
-
-			level.Info(logger).Log("msg", "copying block")
-
-			err = copySingleBlock(ctx, tenantID, blockID, sourceBucket, destBucket)
-			if err != nil {
-				m.blocksCopyFailed.Inc()
-				level.Error(logger).Log("msg", "failed to copy block", "err", err)
-				return err
-			}
-
-			m.blocksCopied.Inc()
-			level.Info(logger).Log("msg", "block copied successfully")
-
-			err = uploadCopiedMarkerFile(ctx, sourceBucket, tenantID, blockID, cfg.destBucket)
-			if err != nil {
-				level.Error(logger).Log("msg", "failed to upload copied-marker file for block", "block", blockID.String(), "err", err)
-				return err
-			}
-			return nil
-		})
-	})
-}
-
-func isAllowedUser(enabled map[string]struct{}, disabled map[string]struct{}, tenantID string) bool {
-	if len(enabled) > 0 {
-		if _, ok := enabled[tenantID]; !ok {
-			return false
-		}
-	}
-
-	if len(disabled) > 0 {
-		if _, ok := disabled[tenantID]; ok {
-			return false
-		}
-	}
-
-	return true
-}
-
-// This method copies files within single TSDB block to a destination bucket.
-func copySingleBlock(ctx context.Context, tenantID string, blockID ulid.ULID, srcBkt, destBkt *storage.BucketHandle) error {
-	paths, err := listPrefix(ctx, srcBkt, tenantID+delim+blockID.String(), true)
-	if err != nil {
-		return errors.Wrapf(err, "copySingleBlock: failed to list block files for %v/%v", tenantID, blockID.String())
-	}
-
-	// Reorder paths, move meta.json at the end. We want to upload meta.json as last file, because it signals to Cortex that
-	// block upload has finished.
-	for ix := 0; ix < len(paths); ix++ {
-		if paths[ix] == block.MetaFilename && ix < len(paths)-1 {
-			paths = append(paths[:ix], paths[ix+1:]...)
-			paths = append(paths, block.MetaFilename)
-		} else {
-			ix++
-		}
-	}
-
-	for _, p := range paths {
-		fullPath := tenantID + delim + blockID.String() + delim + p
-
-		srcObj := srcBkt.Object(fullPath)
-		destObj := destBkt.Object(fullPath)
-
-		copier := destObj.CopierFrom(srcObj)
-		_, err := copier.Run(ctx)
-		if err != nil {
-			return errors.Wrapf(err, "copySingleBlock: failed to copy %v", fullPath)
-		}
-	}
-
-	return nil
-}
-
-func uploadCopiedMarkerFile(ctx context.Context, bkt *storage.BucketHandle, tenantID string, blockID ulid.ULID, targetBucketName string) error {
-	obj := bkt.Object(tenantID + delim + CopiedToBucketMarkFilename(blockID, targetBucketName))
-
-	w := obj.NewWriter(ctx)
-
-	return errors.Wrap(w.Close(), "uploadCopiedMarkerFile")
-}
-
-func loadMetaJSONFile(ctx context.Context, bkt *storage.BucketHandle, tenantID string, blockID ulid.ULID) (metadata.Meta, error) {
-	obj := bkt.Object(tenantID + delim + blockID.String() + delim + block.MetaFilename)
-	r, err := obj.NewReader(ctx)
-	if err != nil {
-		return metadata.Meta{}, errors.Wrapf(err, "failed to read %v", obj.ObjectName())
-	}
-
-	var m metadata.Meta
-
-	dec := json.NewDecoder(r)
-	err = dec.Decode(&m)
-	closeErr := r.Close() // do this before any return.
-
-	if err != nil {
-		return metadata.Meta{}, errors.Wrapf(err, "read %v", obj.ObjectName())
-	}
-	if closeErr != nil {
-		return metadata.Meta{}, errors.Wrapf(err, "close reader for %v", obj.ObjectName())
-	}
-
-	return m, nil
-}
-
-func listTenants(ctx context.Context, bkt *storage.BucketHandle) ([]string, error) {
-	users, err := listPrefix(ctx, bkt, "", false)
-	if err != nil {
-		return nil, err
-	}
-
-	trimDelimSuffix(users)
-
-	return users, nil
-}
-
-func listBlocksForTenant(ctx context.Context, bkt *storage.BucketHandle, tenantID string) ([]ulid.ULID, error) {
-	items, err := listPrefix(ctx, bkt, tenantID, false)
-	if err != nil {
-		return nil, err
-	}
-
-	trimDelimSuffix(items)
-
-	blocks := make([]ulid.ULID, 0, len(items))
-
-	for _, b := range items {
-		if id, ok := block.IsBlockDir(b); ok {
-			blocks = append(blocks, id)
-		}
-	}
-
-	return blocks, nil
-}
-
-// Each block can have multiple markers. This struct combines them together into single struct.
-type blockMarkers struct {
-	deletion bool
-	copied   bool
-}
-
-func listBlockMarkersForTenant(ctx context.Context, bkt *storage.BucketHandle, tenantID string, destinationBucket string) (map[ulid.ULID]blockMarkers, error) {
-	markers, err := listPrefix(ctx, bkt, tenantID+delim+bucketindex.MarkersPathname, false)
-	if err != nil {
-		return nil, err
-	}
-
-	result := map[ulid.ULID]blockMarkers{}
-
-	for _, m := range markers {
-		if id, ok := bucketindex.IsBlockDeletionMarkFilename(m); ok {
-			bm := result[id]
-			bm.deletion = true
-			result[id] = bm
-		}
-
-		if ok, id, targetBucket := IsCopiedToBucketMarkFilename(m); ok && targetBucket == destinationBucket {
-			bm := result[id]
-			bm.copied = true
-			result[id] = bm
-		}
-	}
-
-	return result, nil
-}
-
-func trimDelimSuffix(items []string) {
-	for ix := range items {
-		items[ix] = strings.TrimSuffix(items[ix], delim)
-	}
-}
-
-func listPrefix(ctx context.Context, bkt *storage.BucketHandle, prefix string, recursive bool) ([]string, error) {
-	if len(prefix) > 0 && prefix[len(prefix)-1:] != delim {
-		prefix = prefix + delim
-	}
-
-	q := &storage.Query{
-		Prefix: prefix,
-	}
-	if !recursive {
-		q.Delimiter = delim
-	}
-
-	var result []string
-
-	it := bkt.Objects(ctx, q)
-	for {
-		obj, err := it.Next()
-
-		if err == iterator.Done {
-			break
-		}
-
-		if err != nil {
-			return nil, errors.Wrapf(err, "listPrefix: error listing %v", prefix)
-		}
-
-		path := ""
-		if obj.Prefix != "" { // synthetic directory, only returned when recursive=false
-			path = obj.Prefix
-		} else {
-			path = obj.Name
-		}
-
-		if strings.HasPrefix(path, prefix) {
-			path = strings.TrimPrefix(path, prefix)
-		} else {
-			return nil, errors.Errorf("listPrefix: path has invalid prefix: %v, expected prefix: %v", path, prefix)
-		}
-
-		result = append(result, path)
-	}
-
-	return result, nil
-}
-
-const CopiedMarkFilename = "copied"
-
-// CopiedToBucketMarkFilename returns the path of marker file signalling that block was copied to given destination bucket.
-// Returned path is relative to the tenant's bucket location.
-func CopiedToBucketMarkFilename(blockID ulid.ULID, targetBucket string) string {
-	// eg markers/01EZED0X3YZMNJ3NHGMJJKMHCR-copied-target-bucket
-	return fmt.Sprintf("%s/%s-%s-%s", bucketindex.MarkersPathname, blockID.String(), CopiedMarkFilename, targetBucket)
-}
-
-// IsCopiedToBucketMarkFilename returns whether the input filename matches the expected pattern
-// of copied markers stored in markers location.
-// Target bucket is part of the mark filename, and is returned as 3rd return value.
-func IsCopiedToBucketMarkFilename(name string) (bool, ulid.ULID, string) {
-	parts := strings.SplitN(name, "-", 3)
-	if len(parts) != 3 {
-		return false, ulid.ULID{}, ""
-	}
-
-	// Ensure the 2nd part matches the block copy mark filename.
-	if parts[1] != CopiedMarkFilename {
-		return false, ulid.ULID{}, ""
-	}
-
-	// Ensure the 1st part is a valid block ID.
-	id, err := ulid.Parse(filepath.Base(parts[0]))
-	if err != nil {
-		return false, ulid.ULID{}, ""
-	}
-
-	return true, id, parts[2]
-}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/grafana/dskit/concurrency"
+	"github.com/grafana/dskit/flagext"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+type verifyConfig struct {
+	sourceBucketConfigFile string
+	destBucketConfigFile   string
+	tenantConcurrency      int
+	enabledUsers           flagext.StringSliceCSV
+	disabledUsers          flagext.StringSliceCSV
+	reportFile             string
+}
+
+func (c *verifyConfig) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&c.sourceBucketConfigFile, "source-bucket-config-file", "", "Path to YAML file with the objstore config of the source bucket.")
+	f.StringVar(&c.destBucketConfigFile, "destination-bucket-config-file", "", "Path to YAML file with the objstore config of the destination bucket.")
+	f.IntVar(&c.tenantConcurrency, "tenant-concurrency", 5, "How many tenants to verify at once.")
+	f.Var(&c.enabledUsers, "enabled-users", "If not empty, only these users are verified.")
+	f.Var(&c.disabledUsers, "disabled-users", "If not empty, these users are not verified.")
+	f.StringVar(&c.reportFile, "report-file", "", "If set, write the JSON verify report to this file instead of stdout.")
+}
+
+type verifyMetrics struct {
+	issuesByKind *prometheus.CounterVec
+}
+
+func newVerifyMetrics(reg prometheus.Registerer) *verifyMetrics {
+	return &verifyMetrics{
+		issuesByKind: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_blocks_copy_verify_issues_total",
+			Help: "Number of consistency issues found between source and destination buckets, by kind.",
+		}, []string{"kind"}),
+	}
+}
+
+// VerifyIssue describes a single consistency problem found between the source and
+// destination buckets for a tenant (and, where applicable, a specific block).
+type VerifyIssue struct {
+	Tenant  string `json:"tenant"`
+	Block   string `json:"block,omitempty"`
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// VerifyReport is the structured output of the verify subcommand.
+type VerifyReport struct {
+	Issues []VerifyIssue `json:"issues"`
+}
+
+func mainVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+
+	cfg := verifyConfig{}
+	cfg.RegisterFlags(fs)
+
+	_ = fs.Parse(args)
+
+	logger := log.NewLogfmtLogger(os.Stdout)
+	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+
+	if cfg.sourceBucketConfigFile == "" || cfg.destBucketConfigFile == "" {
+		level.Error(logger).Log("msg", "no source or destination bucket config file given")
+		os.Exit(1)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	reg := prometheus.DefaultRegisterer
+	m := newVerifyMetrics(reg)
+
+	report, err := runVerify(ctx, cfg, logger, reg, m)
+	if err != nil {
+		level.Error(logger).Log("msg", "verify failed", "err", err)
+		os.Exit(1)
+	}
+
+	if err := writeVerifyReport(cfg.reportFile, report); err != nil {
+		level.Error(logger).Log("msg", "failed to write verify report", "err", err)
+		os.Exit(1)
+	}
+
+	level.Info(logger).Log("msg", "verify finished", "issues", len(report.Issues))
+
+	if len(report.Issues) > 0 {
+		os.Exit(1)
+	}
+}
+
+func runVerify(ctx context.Context, cfg verifyConfig, logger log.Logger, reg prometheus.Registerer, m *verifyMetrics) (*VerifyReport, error) {
+	enabledUsers := map[string]struct{}{}
+	disabledUsers := map[string]struct{}{}
+
+	for _, u := range cfg.enabledUsers {
+		enabledUsers[u] = struct{}{}
+	}
+	for _, u := range cfg.disabledUsers {
+		disabledUsers[u] = struct{}{}
+	}
+
+	// verify never writes, so the chunked-rewrite threshold (only consulted by a copy's
+	// destination bucket) doesn't need its own flag here.
+	sourceBucket, err := openBucket(ctx, logger, reg, cfg.sourceBucketConfigFile, "source", defaultGCSChunkedRewriteThresholdBytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open source bucket")
+	}
+	defer sourceBucket.Close()
+
+	destBucket, err := openBucket(ctx, logger, reg, cfg.destBucketConfigFile, "destination", defaultGCSChunkedRewriteThresholdBytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open destination bucket")
+	}
+	defer destBucket.Close()
+
+	tenants, err := listTenants(ctx, sourceBucket)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list tenants")
+	}
+
+	report := &VerifyReport{}
+	var mu sync.Mutex
+
+	err = concurrency.ForEachUser(ctx, tenants, cfg.tenantConcurrency, func(ctx context.Context, tenantID string) error {
+		if !isAllowedUser(enabledUsers, disabledUsers, tenantID) {
+			return nil
+		}
+
+		issues, err := verifyTenant(ctx, log.With(logger, "tenantID", tenantID), sourceBucket, destBucket, tenantID)
+		if err != nil {
+			return errors.Wrapf(err, "failed to verify tenant %v", tenantID)
+		}
+
+		mu.Lock()
+		report.Issues = append(report.Issues, issues...)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, issue := range report.Issues {
+		m.issuesByKind.WithLabelValues(issue.Kind).Inc()
+	}
+
+	return report, nil
+}
+
+func verifyTenant(ctx context.Context, logger log.Logger, srcBkt, destBkt objstore.Bucket, tenantID string) ([]VerifyIssue, error) {
+	blocks, err := listBlocksForTenant(ctx, srcBkt, tenantID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list blocks for tenant %v", tenantID)
+	}
+
+	destBlocks, err := listBlocksForTenant(ctx, destBkt, tenantID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list destination blocks for tenant %v", tenantID)
+	}
+
+	markers, err := listBlockMarkersForTenant(ctx, srcBkt, tenantID, destBkt.Name())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list block markers for tenant %v", tenantID)
+	}
+
+	blockSet := map[ulid.ULID]struct{}{}
+	for _, b := range blocks {
+		blockSet[b] = struct{}{}
+	}
+
+	destBlockSet := map[ulid.ULID]struct{}{}
+	for _, b := range destBlocks {
+		destBlockSet[b] = struct{}{}
+	}
+
+	var issues []VerifyIssue
+	var metas []*metadata.Meta
+
+	for _, blockID := range blocks {
+		partialIssues, err := checkPartialUpload(ctx, srcBkt, "source", tenantID, blockID)
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, partialIssues...)
+
+		// A block present in both buckets can still be mid-copy at the destination (the marker
+		// isn't set until the last file, meta.json, is uploaded), so it's checked here
+		// regardless of whether markers[blockID].copied is set, not only once fully copied.
+		if _, ok := destBlockSet[blockID]; ok {
+			destPartialIssues, err := checkPartialUpload(ctx, destBkt, "destination", tenantID, blockID)
+			if err != nil {
+				return nil, err
+			}
+			issues = append(issues, destPartialIssues...)
+		}
+
+		meta, err := loadMetaJSONFile(ctx, srcBkt, tenantID, blockID)
+		if err != nil {
+			// checkPartialUpload already reported a missing meta.json above; a meta.json that
+			// exists but can't be read or parsed is only logged here. Either way, the
+			// content-based checks below can't run without a parsed meta.json.
+			level.Warn(logger).Log("msg", "skipping content checks for block, meta.json unreadable", "block", blockID, "err", err)
+			continue
+		}
+
+		metaCopy := meta
+		metas = append(metas, &metaCopy)
+
+		if markers[blockID].copied {
+			blockIssues, err := verifyCopiedBlock(ctx, srcBkt, destBkt, tenantID, blockID, &metaCopy)
+			if err != nil {
+				return nil, err
+			}
+			issues = append(issues, blockIssues...)
+		}
+	}
+
+	// Blocks present only in the destination (no source counterpart) are skipped by the loop
+	// above entirely, since it only walks source blocks; a block stuck mid-upload directly in
+	// the destination with no source block to match is exactly the kind of corruption verify
+	// should catch too.
+	for _, blockID := range destBlocks {
+		if _, ok := blockSet[blockID]; ok {
+			continue
+		}
+
+		partialIssues, err := checkPartialUpload(ctx, destBkt, "destination", tenantID, blockID)
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, partialIssues...)
+	}
+
+	for blockID, bm := range markers {
+		if !bm.copied {
+			continue
+		}
+		if _, ok := blockSet[blockID]; !ok {
+			issues = append(issues, VerifyIssue{
+				Tenant:  tenantID,
+				Block:   blockID.String(),
+				Kind:    "stale-copied-marker",
+				Message: "copied marker refers to a block that no longer exists in the source bucket",
+			})
+		}
+	}
+
+	issues = append(issues, findOverlappingBlocks(tenantID, metas)...)
+
+	return issues, nil
+}
+
+// checkPartialUpload flags a block directory in bkt (labelled bucketLabel in issue messages,
+// e.g. "source" or "destination") as a partial upload in two cases: meta.json is missing
+// entirely, or some other file in the block is newer than meta.json, which usually means a
+// file was re-uploaded (or the upload was interrupted and retried) after the block was
+// finalized.
+func checkPartialUpload(ctx context.Context, bkt objstore.Bucket, bucketLabel, tenantID string, blockID ulid.ULID) ([]VerifyIssue, error) {
+	metaPath := tenantID + delim + blockID.String() + delim + block.MetaFilename
+
+	metaAttrs, err := bkt.Attributes(ctx, metaPath)
+	if bkt.IsObjNotFoundErr(err) {
+		return []VerifyIssue{{
+			Tenant:  tenantID,
+			Block:   blockID.String(),
+			Kind:    "partial-upload",
+			Message: fmt.Sprintf("block directory present in %s bucket but meta.json is missing", bucketLabel),
+		}}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read attributes of %v", metaPath)
+	}
+
+	paths, err := listPrefix(ctx, bkt, tenantID+delim+blockID.String(), true)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list files for %v/%v in %s bucket", tenantID, blockID, bucketLabel)
+	}
+
+	var issues []VerifyIssue
+
+	for _, p := range paths {
+		if p == block.MetaFilename {
+			continue
+		}
+
+		fullPath := tenantID + delim + blockID.String() + delim + p
+
+		attrs, err := bkt.Attributes(ctx, fullPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read attributes of %v", fullPath)
+		}
+
+		if attrs.LastModified.After(metaAttrs.LastModified) {
+			issues = append(issues, VerifyIssue{
+				Tenant:  tenantID,
+				Block:   blockID.String(),
+				Kind:    "partial-upload",
+				Message: fmt.Sprintf("%v in %s bucket is newer than meta.json, possible partial or incomplete upload", fullPath, bucketLabel),
+			})
+		}
+	}
+
+	return issues, nil
+}
+
+// verifyCopiedBlock checks that every file the source block has is present in the
+// destination with a matching size, and that the destination meta.json parses and matches
+// the source block's identity and time range.
+func verifyCopiedBlock(ctx context.Context, srcBkt, destBkt objstore.Bucket, tenantID string, blockID ulid.ULID, srcMeta *metadata.Meta) ([]VerifyIssue, error) {
+	var issues []VerifyIssue
+
+	paths, err := listPrefix(ctx, srcBkt, tenantID+delim+blockID.String(), true)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list source files for %v/%v", tenantID, blockID)
+	}
+
+	for _, p := range paths {
+		fullPath := tenantID + delim + blockID.String() + delim + p
+
+		srcAttrs, err := srcBkt.Attributes(ctx, fullPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read attributes of %v", fullPath)
+		}
+
+		destAttrs, err := destBkt.Attributes(ctx, fullPath)
+		if destBkt.IsObjNotFoundErr(err) {
+			issues = append(issues, VerifyIssue{
+				Tenant:  tenantID,
+				Block:   blockID.String(),
+				Kind:    "missing-destination-file",
+				Message: fullPath + " is missing from the destination bucket",
+			})
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read attributes of %v in destination bucket", fullPath)
+		}
+
+		if srcAttrs.Size != destAttrs.Size {
+			issues = append(issues, VerifyIssue{
+				Tenant:  tenantID,
+				Block:   blockID.String(),
+				Kind:    "size-mismatch",
+				Message: fmt.Sprintf("%v has size %d in source but %d in destination", fullPath, srcAttrs.Size, destAttrs.Size),
+			})
+		}
+	}
+
+	destMeta, err := loadMetaJSONFile(ctx, destBkt, tenantID, blockID)
+	switch {
+	case err != nil:
+		issues = append(issues, VerifyIssue{
+			Tenant:  tenantID,
+			Block:   blockID.String(),
+			Kind:    "meta-mismatch",
+			Message: "destination meta.json is missing or unparsable: " + err.Error(),
+		})
+	case destMeta.ULID != srcMeta.ULID || destMeta.MinTime != srcMeta.MinTime || destMeta.MaxTime != srcMeta.MaxTime:
+		issues = append(issues, VerifyIssue{
+			Tenant:  tenantID,
+			Block:   blockID.String(),
+			Kind:    "meta-mismatch",
+			Message: "destination meta.json does not match the source block's identity or time range",
+		})
+	}
+
+	return issues, nil
+}
+
+// findOverlappingBlocks flags pairs of blocks whose [MinTime,MaxTime) ranges intersect but
+// whose compaction levels differ, which typically indicates a duplicate or half-compacted
+// block rather than the expected overlap between adjacent compaction levels.
+func findOverlappingBlocks(tenantID string, metas []*metadata.Meta) []VerifyIssue {
+	sort.Slice(metas, func(i, j int) bool { return metas[i].MinTime < metas[j].MinTime })
+
+	var issues []VerifyIssue
+	var active []*metadata.Meta
+
+	for _, m := range metas {
+		stillActive := active[:0]
+		for _, a := range active {
+			if a.MaxTime > m.MinTime {
+				stillActive = append(stillActive, a)
+			}
+		}
+		active = stillActive
+
+		for _, a := range active {
+			if a.Compaction.Level != m.Compaction.Level {
+				issues = append(issues, VerifyIssue{
+					Tenant: tenantID,
+					Block:  m.ULID.String(),
+					Kind:   "overlapping-blocks",
+					Message: fmt.Sprintf("block %v [%d,%d) level %d overlaps block %v [%d,%d) level %d",
+						m.ULID, m.MinTime, m.MaxTime, m.Compaction.Level,
+						a.ULID, a.MinTime, a.MaxTime, a.Compaction.Level),
+				})
+			}
+		}
+
+		active = append(active, m)
+	}
+
+	return issues
+}
+
+func writeVerifyReport(path string, report *VerifyReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal verify report")
+	}
+	data = append(data, '\n')
+
+	if path == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o644)
+}